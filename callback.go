@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	callbackAttempts  = 3
+	callbackTimeout   = 5 * time.Second
+	callbackRetryWait = time.Second
+	callbackTailLines = 50
+	callbackTailBytes = 64 * 1024
+)
+
+// callbackPayload is posted to --callbackUrl once a deployment has finished:
+// the fields of result.json plus the last lines of the deployment log.
+type callbackPayload struct {
+	DeploymentResult
+	LogTail []string `json:"logTail"`
+}
+
+func ValidateCallbackURL(rawURL string) error {
+	if err := checkHTTPURL(rawURL); err != nil {
+		return fmt.Errorf("callback URL must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// SendCallback posts the deployment outcome to task.CallbackURL. Network
+// errors and 5xx responses are retried a few times; each attempt is bounded
+// by callbackTimeout so a dead endpoint cannot hold up the process for long.
+func SendCallback(task DeploymentTask, result DeploymentResult) error {
+	body, err := json.Marshal(callbackPayload{
+		DeploymentResult: result,
+		LogTail:          logTail(taskLogPath(task.LogPath, task.TaskID), callbackTailLines),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal callback: %v", err)
+	}
+	return postWithRetry(task.CallbackURL, body)
+}
+
+// postWithRetry posts a JSON body, retrying network errors and 5xx
+// responses.
+func postWithRetry(rawURL string, body []byte) error {
+	client := &http.Client{Timeout: callbackTimeout}
+	for attempt := 1; ; attempt++ {
+		retry, err := postCallback(client, rawURL, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == callbackAttempts {
+			return fmt.Errorf("%v (after %d attempts)", err, attempt)
+		}
+		time.Sleep(time.Duration(attempt) * callbackRetryWait)
+	}
+}
+
+// callbackHost is what gets logged about the callback URL. Webhook URLs
+// often carry a secret in their path or query, so only the host is shown.
+func callbackHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "callback URL"
+	}
+	return u.Host
+}
+
+// withoutURL drops the URL the HTTP client wraps around an error, since
+// webhook and collector URLs often carry a secret; see callbackHost.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// postCallback sends one request and reports whether a failure is worth
+// retrying.
+func postCallback(client *http.Client, rawURL string, body []byte) (bool, error) {
+	resp, err := client.Post(rawURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, withoutURL(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// logTail returns up to n trailing lines of the log file, reading at most
+// callbackTailBytes from its end.
+func logTail(path string, n int) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	offset := info.Size() - callbackTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 {
+		// The first line was most likely cut in half
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// containerProjectDir is where the project is mounted in the container.
+	containerProjectDir = "/app"
+	// containerScriptDir holds the read-only mount of the script being run.
+	containerScriptDir = "/deploygo"
+	// dockerRunFailed is the exit status docker run uses for its own errors,
+	// as opposed to a failure of the script in the container.
+	dockerRunFailed = 125
+	// containerRemoveTimeout bounds the cleanup of a stopped container.
+	containerRemoveTimeout = 30 * time.Second
+)
+
+// ValidateDocker checks the options for running the scripts in a container.
+func ValidateDocker(task DeploymentTask) error {
+	if task.DockerImage == "" {
+		return nil
+	}
+	if strings.HasPrefix(task.DockerImage, "-") || strings.ContainsAny(task.DockerImage, " \t\r\n\x00") {
+		return fmt.Errorf("invalid docker image %q", task.DockerImage)
+	}
+	if task.RemoteHost != "" {
+		return fmt.Errorf("--dockerImage cannot be combined with --remoteHost")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker not found on PATH")
+	}
+	return nil
+}
+
+// dockerCommand returns the docker run invocation for one script. The
+// project is mounted at /app and used as the working directory, the script
+// is mounted read-only, and variables are passed by name so their values
+// are taken from docker's environment rather than its command line.
+func dockerCommand(task DeploymentTask, name string, interpreter []string, scriptPath string, scriptArgs []string) []string {
+	script := containerScriptDir + "/" + filepath.Base(scriptPath)
+	argv := []string{
+		"docker", "run", "--rm", "--name", name,
+		"-v", task.ProjectPath + ":" + containerProjectDir,
+		"-v", scriptPath + ":" + script + ":ro",
+		"-w", containerProjectDir,
+	}
+	// Files the script writes into the project stay owned by this user
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 {
+		argv = append(argv, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	seen := map[string]bool{}
+	for _, entry := range scriptEnv(task) {
+		key, _, _ := strings.Cut(entry, "=")
+		if !seen[key] && key != "DEPLOYER_PROJECT_PATH" {
+			seen[key] = true
+			argv = append(argv, "-e", key)
+		}
+	}
+	argv = append(argv, "-e", "DEPLOYER_PROJECT_PATH="+containerProjectDir, "--", task.DockerImage)
+	argv = append(argv, interpreter...)
+	argv = append(argv, script)
+	return append(argv, scriptArgs...)
+}
+
+// runDockerScript runs a script in a fresh container of task.DockerImage,
+// streaming its output into the deployment log like a local script.
+func runDockerScript(ctx context.Context, task DeploymentTask, scriptPath string, scriptArgs []string, label string, logFile *deploymentLog, redactor *redactor, shipper *logShipper) error {
+	args, err := deferredScriptCommand(task.Interpreter, scriptPath)
+	if err != nil {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] Cannot run %s: %v", strings.ToLower(label), err))
+		return fmt.Errorf("cannot run %s: %v", strings.ToLower(label), err)
+	}
+	interpreter := args[:len(args)-1]
+
+	id, err := NewTaskID()
+	if err != nil {
+		return fmt.Errorf("failed to name container: %v", err)
+	}
+	name := "deploygo-" + id
+
+	writeLogEntry(logFile, fmt.Sprintf("%s interpreter: %s (in %s, container %s)", label, strings.Join(interpreter, " "), task.DockerImage, name))
+	err = runCommand(ctx, task, dockerCommand(task, name, interpreter, scriptPath, scriptArgs), nil, label, logFile, redactor, shipper)
+
+	// Killing docker run does not stop the container, so remove it
+	if ctx.Err() != nil {
+		removeCtx, cancel := context.WithTimeout(context.Background(), containerRemoveTimeout)
+		defer cancel()
+		if out, rmErr := exec.CommandContext(removeCtx, "docker", "rm", "-f", name).CombinedOutput(); rmErr != nil {
+			writeLogEntry(logFile, fmt.Sprintf("[WARNING] Failed to remove container %s: %v: %s", name, rmErr, strings.TrimSpace(string(out))))
+		} else {
+			writeLogEntry(logFile, fmt.Sprintf("Removed container %s", name))
+		}
+	}
+	if code, ok := exitCode(err); ok && code == dockerRunFailed {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] Docker could not run image %s; see the docker output above", task.DockerImage))
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ValidateEnvFile ensures the optional env file is an absolute path to a
+// regular file under the allowed script roots that parses cleanly.
+func ValidateEnvFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("env file path must be absolute")
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("env file path does not exist")
+	}
+	if err != nil {
+		return fmt.Errorf("env file could not be inspected: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("env file must be a regular file, not a %s", fileKind(info.Mode()))
+	}
+	if err := checkAllowedScriptRoot(path); err != nil {
+		return fmt.Errorf("env file %v", err)
+	}
+	_, err = parseEnvFile(path)
+	return err
+}
+
+// parseEnvFile reads KEY=VALUE lines from a .env file. Blank lines and
+// comments are skipped, an "export " prefix is allowed, and values may be
+// double-quoted (with escapes) or single-quoted (literal). Errors name the
+// line but never include values.
+func parseEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %v", err)
+	}
+	defer file.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("env file line %d is not a KEY=VALUE assignment", lineNo)
+		}
+		value, err := envFileValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("env file line %d (%s): %v", lineNo, key, err)
+		}
+		if strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("env file line %d (%s): value must not contain NUL bytes", lineNo, key)
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %v", err)
+	}
+	return env, nil
+}
+
+func envFileValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := strings.LastIndex(raw, `"`)
+		if end == 0 || !isEnvComment(raw[end+1:]) {
+			return "", fmt.Errorf("unterminated double quote")
+		}
+		value, err := strconv.Unquote(raw[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted value")
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		end := strings.LastIndex(raw, "'")
+		if end == 0 || !isEnvComment(raw[end+1:]) {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return raw[1:end], nil
+	default:
+		// An unquoted value ends at a " #" comment
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// isEnvComment reports whether what follows a quoted value is empty or a
+// comment.
+func isEnvComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// withEnvFile returns task with the variables from its env file merged
+// into Env. Variables set with --env take precedence.
+func withEnvFile(task DeploymentTask) (DeploymentTask, error) {
+	if task.EnvFile == "" {
+		return task, nil
+	}
+	fileEnv, err := parseEnvFile(task.EnvFile)
+	if err != nil {
+		return task, err
+	}
+	for key, value := range task.Env {
+		fileEnv[key] = value
+	}
+	task.Env = fileEnv
+	return task, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const historyFileName = "history.jsonl"
+
+// AppendHistory adds a deployment result to history.jsonl in the log
+// directory, one JSON object per line, oldest first.
+func AppendHistory(logDir string, result DeploymentResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(logDir, historyFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// One write per entry so concurrent deployments do not interleave lines
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadHistory returns up to limit deployments recorded in the log directory,
+// newest first. A non-empty project keeps only that project's deployments
+// and a limit of 0 returns them all.
+func ReadHistory(logDir, project string, limit int) ([]DeploymentResult, error) {
+	file, err := os.Open(filepath.Join(logDir, historyFileName))
+	if os.IsNotExist(err) {
+		return []DeploymentResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var results []DeploymentResult
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var result DeploymentResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			// A line cut short by a crash; the rest is still usable
+			continue
+		}
+		if project == "" || result.ProjectPath == project {
+			results = append(results, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	newest := make([]DeploymentResult, 0, len(results))
+	for i := len(results) - 1; i >= 0 && (limit == 0 || len(newest) < limit); i-- {
+		newest = append(newest, results[i])
+	}
+	return newest, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const lockPollInterval = 500 * time.Millisecond
+
+// errLockTimeout is returned by waitForLock when its timeout passes.
+var errLockTimeout = errors.New("timed out waiting for the lock")
+
+// projectLock serializes deployments of the same project. It is an advisory
+// lock on a file in the log directory, so it holds across the separate
+// processes that run each deployment.
+type projectLock struct {
+	file *os.File
+}
+
+// acquireProjectLock waits until no other deployment of the project is
+// running, then records the task ID in the lock file. It gives up after
+// task.LockTimeout, if set, or at once with task.FailIfLocked.
+func acquireProjectLock(ctx context.Context, task DeploymentTask, logFile *deploymentLog) (*projectLock, error) {
+	file, err := os.OpenFile(projectLockPath(task), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open project lock: %v", err)
+	}
+
+	locked, err := tryLockFile(file)
+	if err == nil && !locked {
+		if task.FailIfLocked {
+			file.Close()
+			return nil, fmt.Errorf("project is locked by in-progress deployment %s", holderName(task))
+		}
+		writeLogEntry(logFile, fmt.Sprintf("[INFO] Waiting for in-progress deployment %s of %s", holderName(task), task.ProjectPath))
+		err = waitForLock(ctx, file, task.LockTimeout)
+		if errors.Is(err, errLockTimeout) {
+			err = fmt.Errorf("still held by deployment %s after %s", holderName(task), task.LockTimeout)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock project: %w", err)
+	}
+
+	// Record the holder to help operators find a stuck deployment
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(task.TaskID+"\n"), 0)
+	}
+	return &projectLock{file: file}, nil
+}
+
+// waitForLock polls for the lock until it is free, timeout passes or ctx
+// is done. A timeout of 0 waits indefinitely.
+func waitForLock(ctx context.Context, file *os.File, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return errLockTimeout
+		case <-ticker.C:
+		}
+		if locked, err := tryLockFile(file); err != nil || locked {
+			return err
+		}
+	}
+}
+
+// projectLockPath is the lock file of the task's project. It lives in the
+// log directory rather than the project, which the deploy user may not be
+// able to write to and which a script's "git clean -fdx" could delete while
+// the lock is held. The name is keyed by the cleaned project path, and by
+// the host for a remote project.
+func projectLockPath(task DeploymentTask) string {
+	key := filepath.Clean(task.ProjectPath)
+	if task.RemoteHost != "" {
+		key = task.RemoteHost + ":" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := fmt.Sprintf(".deploygo_%s_%s.lock", SafeFileComponent(filepath.Base(key)), hex.EncodeToString(sum[:8]))
+	return filepath.Join(task.LogPath, name)
+}
+
+// lockHolder returns the task ID recorded in the project's lock file.
+func lockHolder(task DeploymentTask) string {
+	data, err := os.ReadFile(projectLockPath(task))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// holderName is lockHolder for messages, which may be empty if the holder
+// has only just taken the lock.
+func holderName(task DeploymentTask) string {
+	if holder := lockHolder(task); holder != "" {
+		return holder
+	}
+	return "(unknown)"
+}
+
+func (l *projectLock) Release() error {
+	unlockErr := unlockFile(l.file)
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return unlockErr
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Project locking relies on flock, which Windows does not provide; there
+// deployments of the same project are not serialized.
+
+const fileLockSupported = false
+
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFlushInterval = 500 * time.Millisecond
+	logBufferSize    = 64 * 1024
+)
+
+// logEcho, when set, receives a copy of every line written to the deployment
+// log. The run command points it at stdout.
+var logEcho io.Writer
+
+// deploymentLog is the active deployment log: the shared deployment.log
+// plus the task's own deployment_<taskID>.log. Writes go through a buffer
+// that is flushed every logFlushInterval, when it fills and on Close,
+// rather than syncing the files after every line. Each flush is a single
+// write ending at a line boundary, so deployments appending to the same
+// deployment.log never split each other's lines. It is safe for concurrent
+// use.
+type deploymentLog struct {
+	mu       sync.Mutex
+	taskID   string
+	file     *os.File
+	taskFile *os.File
+	pending  []byte
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func openDeploymentLog(logDir, taskID string) (*deploymentLog, error) {
+	file, err := os.OpenFile(filepath.Join(logDir, "deployment.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	taskFile, err := os.OpenFile(taskLogPath(logDir, taskID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &deploymentLog{
+		taskID:   taskID,
+		file:     file,
+		taskFile: taskFile,
+		pending:  make([]byte, 0, logBufferSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go l.flushPeriodically()
+	return l, nil
+}
+
+func (l *deploymentLog) WriteString(s string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if logEcho != nil {
+		io.WriteString(logEcho, s)
+	}
+	if len(l.pending)+len(s) > logBufferSize {
+		if err := l.flushLocked(false); err != nil {
+			return 0, err
+		}
+	}
+	l.pending = append(l.pending, s...)
+	if len(l.pending) > logBufferSize {
+		// A single entry larger than the buffer goes out on its own
+		if err := l.flushLocked(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(s), nil
+}
+
+// Flush writes out the buffered complete lines.
+func (l *deploymentLog) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushLocked(false)
+}
+
+// flushLocked writes the buffer up to its last newline, or all of it when
+// all is set, to both files with one write each. l.mu must be held.
+func (l *deploymentLog) flushLocked(all bool) error {
+	end := bytes.LastIndexByte(l.pending, '\n') + 1
+	if all {
+		end = len(l.pending)
+	}
+	if end == 0 {
+		return nil
+	}
+	_, err := l.file.Write(l.pending[:end])
+	if _, taskErr := l.taskFile.Write(l.pending[:end]); err == nil {
+		err = taskErr
+	}
+	l.pending = append(l.pending[:0], l.pending[end:]...)
+	return err
+}
+
+// Close stops the periodic flush, writes out anything still buffered and
+// closes the file.
+func (l *deploymentLog) Close() error {
+	close(l.stop)
+	<-l.done
+	l.mu.Lock()
+	flushErr := l.flushLocked(true)
+	l.mu.Unlock()
+	taskErr := l.taskFile.Close()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if taskErr != nil {
+		return taskErr
+	}
+	return flushErr
+}
+
+// taskLogPath is the log of a single deployment. Its name cannot collide
+// with rotated archives, whose timestamps have no "T".
+func taskLogPath(logDir, taskID string) string {
+	return filepath.Join(logDir, fmt.Sprintf("deployment_%s.log", taskID))
+}
+
+func (l *deploymentLog) flushPeriodically() {
+	defer close(l.done)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// logFormat reads DEPLOYER_LOG_FORMAT: "text" (the default) or "json".
+func logFormat() (string, error) {
+	switch format := os.Getenv("DEPLOYER_LOG_FORMAT"); format {
+	case "", "text":
+		return "text", nil
+	case "json":
+		return format, nil
+	default:
+		return "", fmt.Errorf("DEPLOYER_LOG_FORMAT must be text or json, not %q", format)
+	}
+}
+
+// jsonLogEntry is one line of the log in the json format.
+type jsonLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	TaskID    string `json:"taskId"`
+	Stream    string `json:"stream"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+}
+
+// formatLogEntry renders a log line. stream is STDOUT or STDERR for script
+// output and empty for deployer messages, whose level comes from their
+// [ERROR] or [WARNING] prefix.
+func formatLogEntry(taskID, stream, message string) string {
+	if format, _ := logFormat(); format != "json" {
+		timestamp := time.Now().Format("2006-01-02 15:04:05")
+		if stream != "" {
+			return fmt.Sprintf("[%s] [%s] %s\n", timestamp, stream, message)
+		}
+		return fmt.Sprintf("[%s] %s\n", timestamp, message)
+	}
+
+	entry := jsonLogEntry{
+		Timestamp: time.Now().Format(time.RFC3339Nano),
+		TaskID:    taskID,
+		Stream:    strings.ToLower(stream),
+		Level:     "info",
+		Message:   message,
+	}
+	if stream == "" {
+		entry.Stream = "system"
+		for _, level := range []string{"ERROR", "WARNING", "INFO"} {
+			if rest, ok := strings.CutPrefix(message, "["+level+"] "); ok {
+				entry.Level = strings.ToLower(level)
+				entry.Message = rest
+				break
+			}
+		}
+	}
+	data, _ := json.Marshal(entry)
+	return string(data) + "\n"
+}
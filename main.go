@@ -1,32 +1,171 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// stringList is a flag value that can be repeated, e.g.
+// --expectArtifact=dist/app.bin --expectArtifact=public/build/manifest.json
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// envEnabled reports whether the named environment variable is set to a
+// true value such as "1" or "true".
+func envEnabled(name string) bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(name))
+	return enabled
+}
+
 func main() {
 	deployCmd := flag.NewFlagSet("deploy", flag.ExitOnError)
 	projectPath := deployCmd.String("project", "", "Absolute path to the project directory")
 	deployScript := deployCmd.String("deployScript", "", "Absolute path to the deployment script")
 	logPath := deployCmd.String("logPath", "", "Absolute path to the directory where logs will be stored")
+	var expectArtifacts stringList
+	deployCmd.Var(&expectArtifacts, "expectArtifact", "Path (relative to the project) that must exist after the script succeeds; can be repeated")
+	logShipURL := deployCmd.String("logShipURL", "", "Optional HTTP endpoint that receives batches of log lines as JSON")
+	callbackURL := deployCmd.String("callbackUrl", "", "Optional HTTP endpoint that receives the deployment outcome as JSON when it finishes")
+	preScript := deployCmd.String("preScript", "", "Optional absolute path to a script run before the deployment script; its failure aborts the deployment")
+	postScript := deployCmd.String("postScript", "", "Optional absolute path to a script run after the deployment script, whether or not it succeeded")
+	rollbackScript := deployCmd.String("rollbackScript", "", "Optional absolute path to a script run when the deployment fails")
+	interpreter := deployCmd.String("interpreter", "", "Program to run the scripts with (default: the script's shebang, else bash)")
+	var envVars, scriptArgs stringList
+	deployCmd.Var(&envVars, "env", "KEY=VALUE variable exported to the scripts; can be repeated")
+	envFile := deployCmd.String("envFile", "", "Optional absolute path to a .env file whose variables are exported to the scripts; --env takes precedence")
+	deployCmd.Var(&scriptArgs, "arg", "Argument passed to the deployment script; can be repeated")
+	gitRef := deployCmd.String("gitRef", "", "Optional branch, tag or commit to fetch and check out before the script runs")
+	gitRepo := deployCmd.String("gitRepo", "", "Remote name or URL to fetch --gitRef from (default: origin)")
+	timeout := deployCmd.Int("timeout", 0, "Maximum seconds the deployment script may run before it is killed (0 = no limit)")
+	maxRetries := deployCmd.Int("maxRetries", 0, "Times to re-run the deployment script after it exits non-zero")
+	retryBackoff := deployCmd.Int("retryBackoff", 5, "Seconds to wait before the first retry; doubled for each further retry")
+	maxLogBytes := deployCmd.Int64("maxLogBytes", 10*1024*1024, "Rotate deployment.log once it grows past this many bytes")
+	keepLogs := deployCmd.Int("keepLogs", 10, "Number of rotated log archives to keep (0 = keep all)")
+	var redactPatterns stringList
+	deployCmd.Var(&redactPatterns, "redact", "Regex whose matches are replaced in logged output, in addition to the built-in secret patterns; can be repeated")
+	maintenanceFlag := deployCmd.String("maintenanceFlag", "", "Optional absolute path of a flag file present while the deployment script runs")
+	maxDailyDeploys := deployCmd.Int("maxDailyDeploys", 0, "Maximum deployments per project per day (0 = unlimited)")
+	dayTimezone := deployCmd.String("dayTimezone", "Local", "Timezone whose midnight resets the daily deployment count")
+	remoteHost := deployCmd.String("remoteHost", "", "Optional host to run the scripts on over SSH; --project is then a path on that host")
+	remoteUser := deployCmd.String("remoteUser", "", "User to log in as on --remoteHost (default: from the SSH config)")
+	sshKey := deployCmd.String("sshKey", "", "Optional absolute path to the private key for --remoteHost")
+	dockerImage := deployCmd.String("dockerImage", "", "Optional image to run the scripts in, with the project mounted at /app")
+	force := deployCmd.Bool("force", false, "Deploy even if the daily deployment cap has been reached")
+	dryRun := deployCmd.Bool("dryRun", false, "Validate and log what would run without executing the deployment script")
+	hookTimeout := deployCmd.Int("hookTimeout", 0, "Maximum seconds each of the rollback and post-deploy scripts may run (0 = same as --timeout)")
+	lockTimeout := deployCmd.Int("lockTimeout", 0, "Maximum seconds to wait for another deployment of the project to finish (0 = no limit)")
+	failIfLocked := deployCmd.Bool("failIfLocked", false, "Fail at once instead of waiting when another deployment of the project is running")
+
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	historyLogPath := historyCmd.String("logPath", "", "Absolute path to the log directory of the deployments")
+	historyProject := historyCmd.String("project", "", "Only show deployments of this project path")
+	historyLimit := historyCmd.Int("limit", 20, "Number of deployments to show, newest first (0 = all)")
+
+	tasksCmd := flag.NewFlagSet("tasks", flag.ExitOnError)
+	tasksProject := tasksCmd.String("project", "", "Only show deployments of this project path")
+	tasksStatus := tasksCmd.String("status", "", "Only show deployments with this status: running, waiting or stale")
+
+	clearCmd := flag.NewFlagSet("clear-maintenance", flag.ExitOnError)
+	clearFlag := clearCmd.String("maintenanceFlag", "", "Absolute path of the maintenance flag to remove")
 
 	internalCmd := flag.NewFlagSet("internal-run", flag.ExitOnError)
 	taskFile := internalCmd.String("taskFile", "", "Path to the temporary task file")
 
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: deploygo deploy --project={path} --deployScript={path} --logPath={path}")
+		fmt.Println("Usage: deploygo deploy|run --project={path} --deployScript={path} --logPath={path}")
 		os.Exit(1)
 	}
 
 	switch os.Args[1] {
-	case "deploy":
+	case "deploy", "run":
+		// run takes the same flags but deploys in the foreground
+		deployCmd.Init(os.Args[1], flag.ExitOnError)
 		deployCmd.Parse(os.Args[2:])
-		handleDeploy(*projectPath, *deployScript, *logPath)
+		if deployCmd.NArg() > 0 {
+			fmt.Printf("Error: unexpected argument %q (flags start with --)\n", deployCmd.Arg(0))
+			os.Exit(1)
+		}
+		env, err := ParseEnvAssignments(envVars)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		task := DeploymentTask{
+			ProjectPath:          *projectPath,
+			DeploymentScriptPath: *deployScript,
+			LogPath:              *logPath,
+			ExpectArtifacts:      expectArtifacts,
+			LogShipURL:           *logShipURL,
+			CallbackURL:          *callbackURL,
+			MaintenanceFlagPath:  *maintenanceFlag,
+			RedactPatterns:       redactPatterns,
+			RollbackScriptPath:   *rollbackScript,
+			PreScriptPath:        *preScript,
+			PostScriptPath:       *postScript,
+			ScriptTimeout:        time.Duration(*timeout) * time.Second,
+			HookTimeout:          time.Duration(*hookTimeout) * time.Second,
+			MaxRetries:           *maxRetries,
+			RetryBackoff:         time.Duration(*retryBackoff) * time.Second,
+			Interpreter:          *interpreter,
+			Env:                  env,
+			EnvFile:              *envFile,
+			Args:                 scriptArgs,
+			GitRef:               *gitRef,
+			GitRepo:              *gitRepo,
+			RemoteHost:           *remoteHost,
+			RemoteUser:           *remoteUser,
+			SSHKeyPath:           *sshKey,
+			DockerImage:          *dockerImage,
+			DryRun:               *dryRun,
+			LockTimeout:          time.Duration(*lockTimeout) * time.Second,
+			FailIfLocked:         *failIfLocked,
+			MaxLogBytes:          *maxLogBytes,
+			KeepLogs:             *keepLogs,
+		}
+
+		// Fill in defaults from the project's config file; flags win
+		setFlags := map[string]bool{}
+		deployCmd.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+		task, err = ApplyProjectConfig(task, setFlags)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		limits := deployLimits{
+			MaxDailyDeploys: *maxDailyDeploys,
+			DayTimezone:     *dayTimezone,
+			Force:           *force,
+		}
+		if os.Args[1] == "run" {
+			handleRun(task, limits)
+		} else {
+			handleDeploy(task, limits)
+		}
+	case "history":
+		historyCmd.Parse(os.Args[2:])
+		handleHistory(*historyLogPath, *historyProject, *historyLimit)
+	case "tasks":
+		tasksCmd.Parse(os.Args[2:])
+		handleTasks(*tasksProject, *tasksStatus)
+	case "clear-maintenance":
+		clearCmd.Parse(os.Args[2:])
+		handleClearMaintenance(*clearFlag)
 	case "internal-run":
 		internalCmd.Parse(os.Args[2:])
 		handleInternalRun(*taskFile)
@@ -36,29 +175,24 @@ func main() {
 	}
 }
 
-func handleDeploy(project, script, logs string) {
-	if project == "" || script == "" || logs == "" {
-		fmt.Println("All flags are required: --project, --deployScript, --logPath")
-		os.Exit(1)
-	}
+// deployLimits holds policy checks applied before a deployment is spawned.
+type deployLimits struct {
+	MaxDailyDeploys int
+	DayTimezone     string
+	Force           bool
+}
 
-	// Validate paths
-	if err := ValidatePaths(project, script, logs); err != nil {
+func handleDeploy(task DeploymentTask, limits deployLimits) {
+	task, limits, loc := prepareDeployment(task, limits)
+
+	// Create temporary file for task
+	// Name it after the project so the temp dir shows what is running
+	dir, err := taskDir()
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Create task
-	task := DeploymentTask{
-		ProjectPath:          project,
-		DeploymentScriptPath: script,
-		LogPath:              logs,
-		TaskID:               fmt.Sprintf("%d", time.Now().UnixNano()),
-		CreatedAt:            time.Now(),
-	}
-
-	// Create temporary file for task
-	tmpFile, err := os.CreateTemp("", "deploy_task_*.json")
+	tmpFile, err := os.CreateTemp(dir, fmt.Sprintf("deploy_task_%s_*.json", SafeFileComponent(filepath.Base(task.ProjectPath))))
 	if err != nil {
 		fmt.Printf("Error: Failed to create temporary task file: %v\n", err)
 		os.Exit(1)
@@ -78,10 +212,31 @@ func handleDeploy(project, script, logs string) {
 	}
 	tmpFile.Close()
 
+	// Refuse the deployment if too many are already running, then count it
+	// against the daily change budget
+	if err := CheckQueueCapacity(); err != nil {
+		os.Remove(tmpFile.Name())
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ReserveDailyDeploy(task, limits.MaxDailyDeploys, loc, limits.Force); err != nil {
+		os.Remove(tmpFile.Name())
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Spawn background process
 	// We use the same executable
+	// Undo the task file and daily reservation if no child gets to run it
+	abandon := func() {
+		os.Remove(tmpFile.Name())
+		if err := ReleaseDailyDeploy(task, limits.MaxDailyDeploys, loc); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
 	executable, err := os.Executable()
 	if err != nil {
+		abandon()
 		fmt.Printf("Error: Failed to get executable path: %v\n", err)
 		os.Exit(1)
 	}
@@ -93,14 +248,262 @@ func handleDeploy(project, script, logs string) {
 	// We rely on Start() and not waiting.
 
 	if err := cmd.Start(); err != nil {
+		abandon()
 		fmt.Printf("Error: Failed to start background process: %v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Printf("Deployment started in background for task %s\n", task.TaskID)
+	fmt.Printf("Log: %s (also appended to deployment.log)\n", taskLogPath(task.LogPath, task.TaskID))
+	fmt.Printf("Result: %s\n", filepath.Join(task.LogPath, resultFileName))
 	// Parent exits now
 }
 
+// handleRun deploys in the foreground, copying the log to stdout, and exits
+// with the deployment script's exit code. It bypasses DEPLOYER_MAX_QUEUE but
+// still counts against the daily cap and waits for the project lock.
+func handleRun(task DeploymentTask, limits deployLimits) {
+	task, limits, loc := prepareDeployment(task, limits)
+	if err := ReserveDailyDeploy(task, limits.MaxDailyDeploys, loc, limits.Force); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	logEcho = os.Stdout
+	status, runErr := runTask(task)
+	if status != "failed" {
+		os.Exit(0)
+	}
+	if sig, ok := exitSignal(runErr); ok {
+		os.Exit(128 + int(sig))
+	}
+	if code, ok := exitCode(runErr); ok && code > 0 {
+		os.Exit(code)
+	}
+	os.Exit(1)
+}
+
+// prepareDeployment validates the task and assigns its ID, exiting on the
+// first problem. Dry runs get no daily cap.
+func prepareDeployment(task DeploymentTask, limits deployLimits) (DeploymentTask, deployLimits, *time.Location) {
+	required := []struct{ flag, value string }{
+		{"--project", task.ProjectPath},
+		{"--deployScript", task.DeploymentScriptPath},
+		{"--logPath", task.LogPath},
+	}
+	missing := false
+	for _, r := range required {
+		if r.value == "" {
+			fmt.Printf("Error: %s is required\n", r.flag)
+			missing = true
+		}
+	}
+	if missing {
+		os.Exit(1)
+	}
+
+	// Validate paths; a remote project only exists on the remote host
+	if err := ValidateRemote(task); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateDocker(task); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	validatePaths := ValidatePaths
+	if task.RemoteHost != "" {
+		validatePaths = ValidateRemotePaths
+	}
+	if err := validatePaths(task.ProjectPath, task.DeploymentScriptPath, task.LogPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateGitRef(task.GitRef, task.GitRepo); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateEnvFile(task.EnvFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateArgs(task.Args); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// A remote or container interpreter is looked up where it runs
+	if task.RemoteHost == "" && task.DockerImage == "" {
+		if err := ValidateInterpreter(task.Interpreter); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := ValidateRollbackScript(task.RollbackScriptPath, task.ProjectPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateHookScripts(task.PreScriptPath, task.PostScriptPath, task.ProjectPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateArtifacts(task.ExpectArtifacts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if task.ScriptTimeout < 0 || task.HookTimeout < 0 || task.LockTimeout < 0 {
+		fmt.Println("Error: --timeout, --hookTimeout and --lockTimeout must not be negative")
+		os.Exit(1)
+	}
+	if task.MaxRetries < 0 || task.RetryBackoff < 0 {
+		fmt.Println("Error: --maxRetries and --retryBackoff must not be negative")
+		os.Exit(1)
+	}
+	if task.MaxLogBytes <= 0 || task.KeepLogs < 0 {
+		fmt.Println("Error: --maxLogBytes must be positive and --keepLogs must not be negative")
+		os.Exit(1)
+	}
+	if err := ValidateShipURL(task.LogShipURL); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateCallbackURL(task.CallbackURL); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateMaintenanceFlag(task.MaintenanceFlagPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateRedactPatterns(task.RedactPatterns); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateRedactPatterns(redactEnvPatterns()); err != nil {
+		fmt.Printf("Error: DEPLOYER_REDACT_PATTERNS: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := maxQueue(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := logFormat(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := shutdownGrace(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := logRetention(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Dry runs do not count against the daily change budget
+	loc, err := time.LoadLocation(limits.DayTimezone)
+	if err != nil {
+		fmt.Printf("Error: Invalid timezone %q: %v\n", limits.DayTimezone, err)
+		os.Exit(1)
+	}
+	if task.DryRun {
+		limits.MaxDailyDeploys = 0
+	}
+
+	// Complete task
+	taskID, err := NewTaskID()
+	if err != nil {
+		fmt.Printf("Error: Failed to generate task ID: %v\n", err)
+		os.Exit(1)
+	}
+	task.TaskID = taskID
+	task.CreatedAt = time.Now()
+	return task, limits, loc
+}
+
+func handleHistory(logPath, project string, limit int) {
+	if logPath == "" {
+		fmt.Println("Error: --logPath is required")
+		os.Exit(1)
+	}
+	if !filepath.IsAbs(logPath) {
+		fmt.Println("Error: log path must be absolute")
+		os.Exit(1)
+	}
+	if limit < 0 {
+		fmt.Println("Error: --limit must not be negative")
+		os.Exit(1)
+	}
+
+	results, err := ReadHistory(logPath, project, limit)
+	if err != nil {
+		fmt.Printf("Error: Failed to read history: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Printf("Error: Failed to marshal history: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+func handleTasks(project, status string) {
+	if status != "" && status != "running" && status != "waiting" && status != "stale" {
+		fmt.Println("Error: --status must be running, waiting or stale")
+		os.Exit(1)
+	}
+
+	tasks, err := ListTasks()
+	if err != nil {
+		fmt.Printf("Error: Failed to list tasks: %v\n", err)
+		os.Exit(1)
+	}
+	matching := []TaskSummary{}
+	for _, task := range tasks {
+		if (project == "" || task.ProjectPath == project) && (status == "" || task.Status == status) {
+			matching = append(matching, task)
+		}
+	}
+	data, err := json.MarshalIndent(matching, "", "  ")
+	if err != nil {
+		fmt.Printf("Error: Failed to marshal tasks: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// handleClearMaintenance force-clears a maintenance flag that a killed
+// deployment left behind. A flag whose deployment is still in progress is
+// left alone.
+func handleClearMaintenance(flagPath string) {
+	if flagPath == "" {
+		fmt.Println("Error: --maintenanceFlag is required")
+		os.Exit(1)
+	}
+	if !filepath.IsAbs(flagPath) {
+		fmt.Println("Error: maintenance flag path must be absolute")
+		os.Exit(1)
+	}
+	if data, err := os.ReadFile(flagPath); err == nil {
+		holder, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+		tasks, _ := ListTasks()
+		for _, task := range tasks {
+			if task.TaskID == holder && task.Status != "stale" {
+				fmt.Printf("Error: deployment %s is still in progress and will clear the flag when it finishes\n", holder)
+				os.Exit(1)
+			}
+		}
+	}
+
+	taskID, err := ClearMaintenanceFlag(flagPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Cleared maintenance flag %s set by deployment %s\n", flagPath, taskID)
+}
+
 func handleInternalRun(taskFile string) {
 	if taskFile == "" {
 		fmt.Println("Error: taskFile is required for internal-run")
@@ -108,7 +511,7 @@ func handleInternalRun(taskFile string) {
 	}
 
 	// Read task file
-	data, err := os.ReadFile(taskFile)
+	data, err := ReadTaskFile(taskFile)
 	if err != nil {
 		// Log error to somewhere? We don't have log path yet.
 		// Try to read just to get log path if possible or fail silently/stderr
@@ -118,22 +521,90 @@ func handleInternalRun(taskFile string) {
 
 	var task DeploymentTask
 	if err := json.Unmarshal(data, &task); err != nil {
+		os.Remove(taskFile)
 		fmt.Fprintf(os.Stderr, "Failed to unmarshal task: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Execute deployment
-	if err := ExecuteDeployment(task); err != nil {
-		WriteLog(task.LogPath, fmt.Sprintf("[ERROR] Deployment failed: %v", err))
-	} else {
-		WriteLog(task.LogPath, "[SUCCESS] Deployment completed successfully")
+	// Let the tasks command and DEPLOYER_MAX_QUEUE see this process is alive
+	if release, err := holdTaskFile(taskFile); err == nil {
+		defer release()
 	}
 
-	// Rotate log file
-	if err := RotateLog(task.LogPath); err != nil {
-		// Just log error to active log if possible
-	}
+	runTask(task)
 
 	// Clean up task file
 	os.Remove(taskFile)
 }
+
+// runTask executes the deployment and reports its outcome: the log,
+// result.json, callback and Slack notification. It returns the result status
+// and the deployment error.
+func runTask(task DeploymentTask) (string, error) {
+	// Stopping the process drains the deployment rather than killing it
+	grace, err := shutdownGrace()
+	if err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] %v; using %s", err, defaultShutdownGrace))
+		grace = defaultShutdownGrace
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer cancelOnSignal(task, grace, cancel)()
+
+	// Execute deployment, unless running as an enqueue-only test harness.
+	// Time spent waiting for the project lock counts as queued
+	var startedAt time.Time
+	status := "success"
+	var runErr error
+	if envEnabled("DEPLOYER_EXECUTION_DISABLED") {
+		status = "skipped"
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[SKIPPED] Execution disabled by DEPLOYER_EXECUTION_DISABLED; task %s was not run", task.TaskID))
+	} else if runErr = executeDeployment(ctx, task, &startedAt); runErr != nil {
+		status = "failed"
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[ERROR] Deployment failed: %v", runErr))
+	} else if task.DryRun {
+		status = "dry-run-success"
+		WriteLog(task.LogPath, task.TaskID, "[SUCCESS] Dry run completed successfully")
+	} else {
+		WriteLog(task.LogPath, task.TaskID, "[SUCCESS] Deployment completed successfully")
+	}
+
+	// Publish the outcome for file-based consumers. A deployment that never
+	// started spent all its time queued
+	finishedAt := time.Now()
+	if startedAt.IsZero() {
+		startedAt = finishedAt
+	}
+	result := NewDeploymentResult(task, status, runErr, startedAt, finishedAt)
+	if err := WriteResult(task.LogPath, result); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Failed to write %s: %v", resultFileName, err))
+	}
+	if err := AppendHistory(task.LogPath, result); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Failed to write %s: %v", historyFileName, err))
+	}
+
+	// Notify the caller's webhook, before rotation so the log tail is complete
+	if task.CallbackURL != "" {
+		if err := SendCallback(task, result); err != nil {
+			WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Callback to %s failed: %v", callbackHost(task.CallbackURL), err))
+		} else {
+			WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("Callback delivered to %s", callbackHost(task.CallbackURL)))
+		}
+	}
+	if err := sendSlackNotification(task, result); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Slack notification failed: %v", err))
+	}
+
+	// Rotate log file once it is large enough
+	if err := RotateLog(task.LogPath, task.MaxLogBytes, task.KeepLogs); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Failed to rotate log: %v", err))
+	}
+	if retention, err := logRetention(); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] %v; not pruning old logs", err))
+	} else if removed, err := PruneOldLogs(task.LogPath, task.TaskID, retention); err != nil {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Failed to prune old logs: %v", err))
+	} else if removed > 0 {
+		WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("Removed %d log files older than %d days", removed, int(retention.Hours()/24)))
+	}
+	return status, runErr
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// setMaintenanceFlag creates the task's maintenance flag, recording the task
+// ID and start time in it. It reports false, without an error, when a file
+// already exists at the path: that may be a flag an operator set by hand, or
+// any other file, so it is neither overwritten nor removed afterwards.
+func setMaintenanceFlag(task DeploymentTask) (bool, error) {
+	file, err := os.OpenFile(task.MaintenanceFlagPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = fmt.Fprintf(file, "%s %s\n", task.TaskID, time.Now().Format(time.RFC3339))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(task.MaintenanceFlagPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// ClearMaintenanceFlag removes a maintenance flag left behind by a
+// deployment whose process was killed, returning the ID of the task that set
+// it. Files deploygo did not write are refused.
+func ClearMaintenanceFlag(flagPath string) (string, error) {
+	info, err := os.Lstat(flagPath)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no maintenance flag at %s", flagPath)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is a %s, not a maintenance flag", flagPath, fileKind(info.Mode()))
+	}
+	data, err := os.ReadFile(flagPath)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("%s was not written by deploygo; remove it by hand if it is safe to", flagPath)
+	}
+	if _, err := time.Parse(time.RFC3339, fields[1]); err != nil {
+		return "", fmt.Errorf("%s was not written by deploygo; remove it by hand if it is safe to", flagPath)
+	}
+	if err := os.Remove(flagPath); err != nil {
+		return "", err
+	}
+	return fields[0], nil
+}
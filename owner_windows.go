@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// fileOwner is not supported on Windows, which has no numeric file owners.
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// preflightScripts checks every script of the deployment before anything
+// runs: it warns about CRLF line endings and fails on bash syntax errors,
+// which would otherwise surface part-way through the deployment.
+func preflightScripts(task DeploymentTask, logFile *deploymentLog, redactor *redactor) error {
+	scripts := []struct{ label, path string }{
+		{"Pre-deploy script", task.PreScriptPath},
+		{"Deployment script", task.DeploymentScriptPath},
+		{"Post-deploy script", task.PostScriptPath},
+		{"Rollback script", task.RollbackScriptPath},
+	}
+	for _, script := range scripts {
+		if script.path == "" {
+			continue
+		}
+		if err := preflightScript(task, script.path, script.label, logFile, redactor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func preflightScript(task DeploymentTask, scriptPath, label string, logFile *deploymentLog, redactor *redactor) error {
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] Cannot read %s: %v", strings.ToLower(label), err))
+		return fmt.Errorf("cannot read %s: %v", strings.ToLower(label), err)
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		writeLogEntry(logFile, fmt.Sprintf("[WARNING] %s has CRLF line endings; convert it with dos2unix if it fails with $'\\r' errors", label))
+	}
+
+	argv, err := deferredScriptCommand(task.Interpreter, scriptPath)
+	if err != nil || !runsWithBash(argv[:len(argv)-1]) {
+		return nil
+	}
+	// Remote and container scripts may have no bash on this host to check with
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return nil
+	}
+	output, err := exec.Command(bash, "-n", scriptPath).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] %s", redactor.Redact(line)))
+	}
+	writeLogEntry(logFile, fmt.Sprintf("[ERROR] %s has syntax errors; nothing was run", label))
+	return fmt.Errorf("%s has syntax errors", strings.ToLower(label))
+}
+
+// runsWithBash reports whether an interpreter command line is bash, either
+// directly or through env.
+func runsWithBash(interpreter []string) bool {
+	if len(interpreter) == 0 {
+		return false
+	}
+	if filepath.Base(interpreter[0]) == "env" && len(interpreter) > 1 {
+		interpreter = strings.Fields(interpreter[1])
+	}
+	return filepath.Base(interpreter[0]) == "bash"
+}
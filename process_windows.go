@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows, where there are no process groups
+// to signal; cancellation kills only the script process.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const projectConfigName = ".deployer.json"
+
+// projectConfig holds deployment defaults committed in the project
+// directory. Keys are named after the matching deploy flags; relative paths
+// are relative to the project.
+type projectConfig struct {
+	DeployScript   string            `json:"deployScript"`
+	PreScript      string            `json:"preScript"`
+	PostScript     string            `json:"postScript"`
+	RollbackScript string            `json:"rollbackScript"`
+	Interpreter    string            `json:"interpreter"`
+	Env            map[string]string `json:"env"`
+	Timeout        *int              `json:"timeout"`
+	HookTimeout    *int              `json:"hookTimeout"`
+	MaxRetries     *int              `json:"maxRetries"`
+	RetryBackoff   *int              `json:"retryBackoff"`
+}
+
+// ApplyProjectConfig fills in task settings from .deployer.json in the
+// project directory. setFlags names the flags given on the command line,
+// which take precedence over the file; --env variables override file
+// variables of the same name.
+func ApplyProjectConfig(task DeploymentTask, setFlags map[string]bool) (DeploymentTask, error) {
+	// A remote project's directory is not on this machine
+	if task.RemoteHost != "" || !filepath.IsAbs(task.ProjectPath) {
+		return task, nil
+	}
+	if info, err := os.Stat(task.ProjectPath); err != nil || !info.IsDir() {
+		return task, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(task.ProjectPath, projectConfigName))
+	if os.IsNotExist(err) {
+		return task, nil
+	}
+	if err != nil {
+		return task, fmt.Errorf("failed to read %s: %v", projectConfigName, err)
+	}
+	var cfg projectConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return task, fmt.Errorf("invalid %s: %v", projectConfigName, err)
+	}
+
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(task.ProjectPath, path)
+	}
+	if cfg.DeployScript != "" && !setFlags["deployScript"] {
+		task.DeploymentScriptPath = resolve(cfg.DeployScript)
+	}
+	if cfg.PreScript != "" && !setFlags["preScript"] {
+		task.PreScriptPath = resolve(cfg.PreScript)
+	}
+	if cfg.PostScript != "" && !setFlags["postScript"] {
+		task.PostScriptPath = resolve(cfg.PostScript)
+	}
+	if cfg.RollbackScript != "" && !setFlags["rollbackScript"] {
+		task.RollbackScriptPath = resolve(cfg.RollbackScript)
+	}
+	if cfg.Interpreter != "" && !setFlags["interpreter"] {
+		task.Interpreter = cfg.Interpreter
+	}
+	if cfg.Timeout != nil && !setFlags["timeout"] {
+		task.ScriptTimeout = time.Duration(*cfg.Timeout) * time.Second
+	}
+	if cfg.HookTimeout != nil && !setFlags["hookTimeout"] {
+		task.HookTimeout = time.Duration(*cfg.HookTimeout) * time.Second
+	}
+	if cfg.MaxRetries != nil && !setFlags["maxRetries"] {
+		task.MaxRetries = *cfg.MaxRetries
+	}
+	if cfg.RetryBackoff != nil && !setFlags["retryBackoff"] {
+		task.RetryBackoff = time.Duration(*cfg.RetryBackoff) * time.Second
+	}
+
+	if len(cfg.Env) > 0 {
+		env := make(map[string]string, len(cfg.Env)+len(task.Env))
+		for key, value := range cfg.Env {
+			if !envKeyPattern.MatchString(key) || strings.ContainsRune(value, 0) {
+				return task, fmt.Errorf("invalid %s: bad environment variable %q", projectConfigName, key)
+			}
+			env[key] = value
+		}
+		for key, value := range task.Env {
+			env[key] = value
+		}
+		task.Env = env
+	}
+	return task, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// taskFilePattern matches the task files of deployments that have been
+// started but not finished; each background process deletes its own.
+const taskFilePattern = "deploy_task_*.json"
+
+// taskStartGrace is how long a new task file may go unlocked before its
+// background process is assumed to have died.
+const taskStartGrace = 30 * time.Second
+
+// taskDir is where task files are written: DEPLOYER_DATA_DIR if set,
+// otherwise the system temp directory. A configured directory is created
+// with 0700 permissions.
+func taskDir() (string, error) {
+	dir := os.Getenv("DEPLOYER_DATA_DIR")
+	if dir == "" {
+		return os.TempDir(), nil
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("DEPLOYER_DATA_DIR must be an absolute path")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create DEPLOYER_DATA_DIR: %v", err)
+	}
+	// An existing directory others can write to would let them swap task files
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect DEPLOYER_DATA_DIR: %v", err)
+	}
+	if _, ok := fileOwner(info); ok && info.Mode().Perm()&0022 != 0 {
+		return "", fmt.Errorf("DEPLOYER_DATA_DIR must not be writable by other users (mode %04o)", info.Mode().Perm())
+	}
+	return dir, nil
+}
+
+// ReadTaskFile reads a task file for internal-run, refusing files another
+// local user could have written: it must be a regular file owned by the
+// current user and not accessible to anyone else.
+func ReadTaskFile(path string) ([]byte, error) {
+	linkInfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !linkInfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("task file must be a regular file, not a %s", fileKind(linkInfo.Mode()))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Check the file that was opened, in case the path was swapped meanwhile
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !os.SameFile(linkInfo, info) {
+		return nil, fmt.Errorf("task file changed while it was being opened")
+	}
+	if owner, ok := fileOwner(info); ok {
+		if owner != os.Getuid() {
+			return nil, fmt.Errorf("task file is owned by UID %d, not the current UID %d", owner, os.Getuid())
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("task file must not be accessible to other users (mode %04o)", info.Mode().Perm())
+		}
+	}
+	return io.ReadAll(file)
+}
+
+// maxQueue reads DEPLOYER_MAX_QUEUE, the maximum number of deployments in
+// progress at once. 0 or unset means no limit.
+func maxQueue() (int, error) {
+	value := os.Getenv("DEPLOYER_MAX_QUEUE")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("DEPLOYER_MAX_QUEUE must be a non-negative integer")
+	}
+	return limit, nil
+}
+
+// CheckQueueCapacity fails when more than DEPLOYER_MAX_QUEUE deployments are
+// in progress. It is called once the new task file exists, so concurrent
+// callers cannot all slip under the limit.
+func CheckQueueCapacity() error {
+	limit, err := maxQueue()
+	if err != nil || limit == 0 {
+		return err
+	}
+	dir, err := taskDir()
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, taskFilePattern))
+	if err != nil {
+		return fmt.Errorf("failed to count deployments in progress: %v", err)
+	}
+	inProgress := 0
+	for _, path := range paths {
+		if taskAlive(path) {
+			inProgress++
+		}
+	}
+	if inProgress > limit {
+		return fmt.Errorf("too many deployments in progress (%d, limit %d); try again later", inProgress-1, limit)
+	}
+	return nil
+}
+
+// holdTaskFile locks the task file for as long as its deployment runs, which
+// is how taskAlive tells a live deployment from one whose process died.
+func holdTaskFile(path string) (func(), error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// taskAlive may be holding the lock for a moment
+	locked, err := tryLockFile(file)
+	if err == nil && !locked {
+		err = waitForLock(context.Background(), file, time.Second)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// taskAlive reports whether a task file belongs to a background process
+// that is still running. Files younger than taskStartGrace count as alive,
+// since their process may not have locked them yet.
+func taskAlive(path string) bool {
+	if !fileLockSupported {
+		return true
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	locked, err := tryLockFile(file)
+	if err != nil || !locked {
+		return true
+	}
+	unlockFile(file)
+	info, err := file.Stat()
+	return err == nil && time.Since(info.ModTime()) < taskStartGrace
+}
+
+// TaskSummary describes a deployment in progress for the tasks command. It
+// leaves out the variables and arguments, which may hold secrets.
+type TaskSummary struct {
+	TaskID      string    `json:"taskId"`
+	ProjectPath string    `json:"projectPath"`
+	ScriptPath  string    `json:"scriptPath"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	AgeSeconds  float64   `json:"ageSeconds"`
+}
+
+// ListTasks returns the deployments started with deploy that have not
+// finished, oldest first. A deployment is "running" once it holds its
+// project lock and "waiting" before that; "stale" ones left a task file
+// behind when their process died.
+func ListTasks() ([]TaskSummary, error) {
+	dir, err := taskDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, taskFilePattern))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []TaskSummary{}
+	for _, path := range paths {
+		// Files of other users, or ones finished meanwhile, are skipped
+		data, err := ReadTaskFile(path)
+		if err != nil {
+			continue
+		}
+		var task DeploymentTask
+		if err := json.Unmarshal(data, &task); err != nil {
+			continue
+		}
+		status := "waiting"
+		if !taskAlive(path) {
+			status = "stale"
+		} else if lockHolder(task) == task.TaskID {
+			status = "running"
+		}
+		tasks = append(tasks, TaskSummary{
+			TaskID:      task.TaskID,
+			ProjectPath: task.ProjectPath,
+			ScriptPath:  task.DeploymentScriptPath,
+			Status:      status,
+			CreatedAt:   task.CreatedAt,
+			AgeSeconds:  time.Since(task.CreatedAt).Seconds(),
+		})
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+	return tasks, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSameProjectDeploymentsRunInTurn(t *testing.T) {
+	if !fileLockSupported {
+		t.Skip("file locking is not supported on this platform")
+	}
+	// Each script records when it starts and ends in a shared file
+	events := filepath.Join(t.TempDir(), "events")
+	script := "echo start >> " + events + "\nsleep 1\necho end >> " + events + "\n"
+
+	tests := []struct {
+		name        string
+		sameProject bool
+		want        string
+	}{
+		{"same project", true, "start\nend\nstart\nend\n"},
+		{"different projects", false, "start\nstart\nend\nend\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(events)
+			first := newTestDeployment(t, script)
+			second := newTestDeployment(t, script)
+			second.LogPath = first.LogPath
+			if tt.sameProject {
+				second.ProjectPath = first.ProjectPath
+			}
+
+			var wg sync.WaitGroup
+			for _, task := range []DeploymentTask{first, second} {
+				wg.Add(1)
+				go func(task DeploymentTask) {
+					defer wg.Done()
+					if err := ExecuteDeploymentContext(context.Background(), task); err != nil {
+						t.Error(err)
+					}
+				}(task)
+			}
+			wg.Wait()
+
+			data, err := os.ReadFile(events)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Fatalf("events = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+// lockTestTask returns a task for lock tests. Copies of it with another
+// TaskID contend for the same lock, which lives in the shared LogPath.
+func lockTestTask(t *testing.T, id string) DeploymentTask {
+	t.Helper()
+	return DeploymentTask{ProjectPath: t.TempDir(), LogPath: t.TempDir(), TaskID: id}
+}
+
+func openTestLog(t *testing.T, task DeploymentTask) *deploymentLog {
+	t.Helper()
+	logFile, err := openDeploymentLog(task.LogPath, task.TaskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logFile.Close() })
+	return logFile
+}
+
+func TestProjectLock(t *testing.T) {
+	if !fileLockSupported {
+		t.Skip("file locking is not supported on this platform")
+	}
+	first := lockTestTask(t, "first")
+	lock, err := acquireProjectLock(context.Background(), first, openTestLog(t, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lockHolder(first); got != "first" {
+		t.Fatalf("lockHolder = %q, want %q", got, "first")
+	}
+
+	tests := []struct {
+		name         string
+		failIfLocked bool
+		lockTimeout  time.Duration
+		ctxTimeout   time.Duration
+		wantErr      string
+		wantIs       error
+	}{
+		{name: "fail if locked", failIfLocked: true, wantErr: "locked by in-progress deployment first"},
+		{name: "lock timeout", lockTimeout: time.Second, wantErr: "still held by deployment first after 1s"},
+		{name: "cancelled", ctxTimeout: time.Second, wantIs: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := first
+			second.TaskID = "second"
+			second.FailIfLocked = tt.failIfLocked
+			second.LockTimeout = tt.lockTimeout
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+			logFile := openTestLog(t, second)
+
+			_, err := acquireProjectLock(ctx, second, logFile)
+			if err == nil {
+				t.Fatal("acquired a lock that is held")
+			}
+			if tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Fatalf("error = %v, want %v", err, tt.wantIs)
+			}
+			if got := lockHolder(first); got != "first" {
+				t.Fatalf("lockHolder = %q after a failed attempt", got)
+			}
+		})
+	}
+
+	// A waiting deployment starts once the holder releases the lock
+	second := first
+	second.TaskID = "second"
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := acquireProjectLock(context.Background(), second, openTestLog(t, second))
+		if err == nil {
+			lock.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(2 * lockPollInterval)
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting deployment did not get the lock after it was released")
+	}
+	if got := lockHolder(first); got != "second" {
+		t.Fatalf("lockHolder = %q, want %q", got, "second")
+	}
+	data, err := os.ReadFile(filepath.Join(second.LogPath, "deployment.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Waiting for in-progress deployment first of "+first.ProjectPath) {
+		t.Fatalf("wait was not logged with the holder:\n%s", data)
+	}
+}
+
+func TestProjectLockPath(t *testing.T) {
+	task := DeploymentTask{ProjectPath: "/var/www/app", LogPath: "/var/log/deploygo"}
+	path := projectLockPath(task)
+	if filepath.Dir(path) != filepath.Clean(task.LogPath) {
+		t.Fatalf("lock %s is not in the log directory", path)
+	}
+
+	tests := []struct {
+		name string
+		task DeploymentTask
+		same bool
+	}{
+		{"trailing slash", DeploymentTask{ProjectPath: "/var/www/app/", LogPath: task.LogPath}, true},
+		{"dot segments", DeploymentTask{ProjectPath: "/var/www/./other/../app", LogPath: task.LogPath}, true},
+		{"other project", DeploymentTask{ProjectPath: "/var/www/other", LogPath: task.LogPath}, false},
+		{"same name elsewhere", DeploymentTask{ProjectPath: "/srv/app", LogPath: task.LogPath}, false},
+		{"remote host", DeploymentTask{ProjectPath: "/var/www/app", LogPath: task.LogPath, RemoteHost: "web1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := projectLockPath(tt.task) == path; same != tt.same {
+				t.Fatalf("same lock = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestTaskAlive(t *testing.T) {
+	if !fileLockSupported {
+		t.Skip("file locking is not supported on this platform")
+	}
+	dir := t.TempDir()
+	newTaskFile := func(name string, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	held := newTaskFile("deploy_task_held.json", time.Hour)
+	release, err := holdTaskFile(held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"held by its process", held, true},
+		{"new and not yet held", newTaskFile("deploy_task_new.json", 0), true},
+		{"old and not held", newTaskFile("deploy_task_dead.json", 2*taskStartGrace), false},
+		{"missing", filepath.Join(dir, "deploy_task_gone.json"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := taskAlive(tt.path); got != tt.want {
+				t.Fatalf("taskAlive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Checking must not take the lock away from its holder
+	if !taskAlive(held) {
+		t.Fatal("held task file reported dead after being checked")
+	}
+}
+
+func TestCheckQueueCapacity(t *testing.T) {
+	if !fileLockSupported {
+		t.Skip("file locking is not supported on this platform")
+	}
+	dir := filepath.Join(t.TempDir(), "data")
+	t.Setenv("DEPLOYER_DATA_DIR", dir)
+	if _, err := taskDir(); err != nil {
+		t.Fatal(err)
+	}
+	writeTask := func(name string, age time.Duration) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		limit   string
+		tasks   map[string]time.Duration
+		wantErr bool
+	}{
+		{name: "unlimited", limit: "", tasks: map[string]time.Duration{"deploy_task_a.json": 0, "deploy_task_b.json": 0}},
+		{name: "at the limit", limit: "2", tasks: map[string]time.Duration{"deploy_task_a.json": 0, "deploy_task_b.json": 0}},
+		{name: "over the limit", limit: "1", tasks: map[string]time.Duration{"deploy_task_a.json": 0, "deploy_task_b.json": 0}, wantErr: true},
+		{name: "stale files are not counted", limit: "1", tasks: map[string]time.Duration{"deploy_task_a.json": 0, "deploy_task_b.json": time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEPLOYER_MAX_QUEUE", tt.limit)
+			old, _ := filepath.Glob(filepath.Join(dir, taskFilePattern))
+			for _, path := range old {
+				os.Remove(path)
+			}
+			for name, age := range tt.tasks {
+				writeTask(name, age)
+			}
+			if err := CheckQueueCapacity(); (err != nil) != tt.wantErr {
+				t.Fatalf("CheckQueueCapacity() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	stateFileName    = ".deploygo_state.json"
+	stateLockName    = ".deploygo_state.lock"
+	stateLockTimeout = 30 * time.Second
+)
+
+// dailyCount tracks how many deployments a project had on a given day.
+type dailyCount struct {
+	Day   string
+	Count int
+}
+
+// deployState is persisted in the log directory between invocations.
+type deployState struct {
+	DailyDeploys map[string]dailyCount
+}
+
+func loadState(logDir string) (deployState, error) {
+	state := deployState{DailyDeploys: map[string]dailyCount{}}
+	data, err := os.ReadFile(filepath.Join(logDir, stateFileName))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state file: %v", err)
+	}
+	if state.DailyDeploys == nil {
+		state.DailyDeploys = map[string]dailyCount{}
+	}
+	return state, nil
+}
+
+func saveState(logDir string, state deployState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(logDir, stateFileName), data, 0644)
+}
+
+// ReserveDailyDeploy counts a deployment against the project's daily cap.
+// Days roll over at midnight in loc. A limit of 0 disables the cap; force
+// records the deployment even when the cap has been reached.
+func ReserveDailyDeploy(task DeploymentTask, limit int, loc *time.Location, force bool) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	// Concurrent deploys must not read the same count and both pass
+	unlock, err := lockState(task.LogPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := loadState(task.LogPath)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().In(loc).Format("2006-01-02")
+	entry := state.DailyDeploys[task.ProjectPath]
+	if entry.Day != today {
+		entry = dailyCount{Day: today}
+	}
+
+	if entry.Count >= limit && !force {
+		return fmt.Errorf("daily deployment cap reached for %s (cap %d, current %d); use --force to override", task.ProjectPath, limit, entry.Count)
+	}
+
+	entry.Count++
+	state.DailyDeploys[task.ProjectPath] = entry
+	return saveState(task.LogPath, state)
+}
+
+// ReleaseDailyDeploy gives back a deployment counted by ReserveDailyDeploy
+// that never started.
+func ReleaseDailyDeploy(task DeploymentTask, limit int, loc *time.Location) error {
+	if limit <= 0 {
+		return nil
+	}
+	unlock, err := lockState(task.LogPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := loadState(task.LogPath)
+	if err != nil {
+		return err
+	}
+	entry := state.DailyDeploys[task.ProjectPath]
+	if entry.Day != time.Now().In(loc).Format("2006-01-02") || entry.Count == 0 {
+		return nil
+	}
+	entry.Count--
+	state.DailyDeploys[task.ProjectPath] = entry
+	return saveState(task.LogPath, state)
+}
+
+// lockState takes an exclusive lock guarding the state file of logDir. The
+// lock is on a separate file, since saving replaces the state file.
+func lockState(logDir string) (func(), error) {
+	file, err := os.OpenFile(filepath.Join(logDir, stateLockName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state lock: %v", err)
+	}
+	locked, err := tryLockFile(file)
+	if err == nil && !locked {
+		err = waitForLock(context.Background(), file, stateLockTimeout)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock state file: %v", err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const redactedText = "***REDACTED***"
+
+// minRedactedValueLen is the shortest variable value that is redacted
+// automatically; shorter values such as "1" or "true" would match too much.
+const minRedactedValueLen = 8
+
+// defaultRedactPatterns catch the secrets most commonly echoed by deploy
+// scripts. User patterns passed with --redact are applied in addition.
+var defaultRedactPatterns = []string{
+	`AKIA[0-9A-Z]{16}`,                   // AWS access key ID
+	`gh[pousr]_[A-Za-z0-9]{36,}`,         // GitHub tokens
+	`xox[abprs]-[A-Za-z0-9-]{10,}`,       // Slack tokens
+	`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`, // Authorization headers
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----`, // PEM private keys
+	`(?i)(password|passwd|secret|token|api[_-]?key)\s*[=:]\s*\S+`,
+}
+
+// redactor scrubs secrets from log lines and counts how many it replaced.
+// A nil *redactor passes lines through unchanged.
+type redactor struct {
+	patterns []*regexp.Regexp
+	count    atomic.Int64
+}
+
+func ValidateRedactPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// redactEnvPatterns returns the patterns in DEPLOYER_REDACT_PATTERNS, one
+// per line.
+func redactEnvPatterns() []string {
+	var patterns []string
+	for _, line := range strings.Split(os.Getenv("DEPLOYER_REDACT_PATTERNS"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// envValuePatterns match the values of the task's custom variables, which
+// are often credentials.
+func envValuePatterns(env map[string]string) []string {
+	var patterns []string
+	for _, value := range env {
+		if len(value) >= minRedactedValueLen {
+			patterns = append(patterns, regexp.QuoteMeta(value))
+		}
+	}
+	// Longer values first, so one that contains another is replaced whole
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	return patterns
+}
+
+func newRedactor(extra []string) (*redactor, error) {
+	r := &redactor{}
+	for _, pattern := range append(append([]string{}, defaultRedactPatterns...), extra...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact replaces every match of the configured patterns in line.
+func (r *redactor) Redact(line string) string {
+	if r == nil {
+		return line
+	}
+	for _, re := range r.patterns {
+		line = re.ReplaceAllStringFunc(line, func(string) string {
+			r.count.Add(1)
+			return redactedText
+		})
+	}
+	return line
+}
+
+// Count returns the number of redactions applied so far.
+func (r *redactor) Count() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.count.Load()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sshConnectionFailed is the exit status ssh uses for its own errors, as
+// opposed to a failure of the remote command.
+const sshConnectionFailed = 255
+
+// ValidateRemote checks the options for running the scripts on a remote
+// host over SSH.
+func ValidateRemote(task DeploymentTask) error {
+	if task.RemoteHost == "" {
+		if task.RemoteUser != "" || task.SSHKeyPath != "" {
+			return fmt.Errorf("--remoteUser and --sshKey require --remoteHost")
+		}
+		return nil
+	}
+	if strings.HasPrefix(task.RemoteHost, "-") || strings.ContainsAny(task.RemoteHost, "@ \t\r\n\x00") {
+		return fmt.Errorf("invalid remote host %q", task.RemoteHost)
+	}
+	if strings.HasPrefix(task.RemoteUser, "-") || strings.ContainsAny(task.RemoteUser, "@ \t\r\n\x00") {
+		return fmt.Errorf("invalid remote user %q", task.RemoteUser)
+	}
+	if task.SSHKeyPath != "" {
+		if !filepath.IsAbs(task.SSHKeyPath) {
+			return fmt.Errorf("SSH key path must be absolute")
+		}
+		if _, err := os.Stat(task.SSHKeyPath); err != nil {
+			return fmt.Errorf("SSH key path does not exist")
+		}
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return fmt.Errorf("ssh not found on PATH")
+	}
+
+	// These work on the local project directory
+	switch {
+	case task.GitRef != "":
+		return fmt.Errorf("--remoteHost cannot be combined with --gitRef")
+	case len(task.ExpectArtifacts) > 0:
+		return fmt.Errorf("--remoteHost cannot be combined with --expectArtifact")
+	case task.MaintenanceFlagPath != "":
+		return fmt.Errorf("--remoteHost cannot be combined with --maintenanceFlag")
+	}
+	return nil
+}
+
+// remoteTarget is how the remote host is shown in the log.
+func remoteTarget(task DeploymentTask) string {
+	if task.RemoteUser == "" {
+		return task.RemoteHost
+	}
+	return task.RemoteUser + "@" + task.RemoteHost
+}
+
+// deferredScriptCommand is scriptCommand for a script that runs elsewhere,
+// on a remote host or in a container: the interpreter is only looked up
+// there.
+func deferredScriptCommand(interpreter, scriptPath string) ([]string, error) {
+	if interpreter != "" {
+		return []string{interpreter, scriptPath}, nil
+	}
+	shebang, err := readShebang(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(shebang) == 0 {
+		return []string{"bash", scriptPath}, nil
+	}
+	return append(shebang, scriptPath), nil
+}
+
+// sshCommand returns the ssh invocation that runs interpreter on the remote
+// host in the project directory. ssh does not forward the environment, so
+// the remote shell first reads the exports written by sshEnvPreamble from
+// stdin and then hands the rest of stdin, the script, to the interpreter.
+// Variable values never appear on either host's command line.
+func sshCommand(task DeploymentTask, interpreter []string, scriptArgs []string) []string {
+	remote := []string{
+		"cd", shellQuote(task.ProjectPath), "&&",
+		`nl=$(printf '\n_') && nl=${nl%_} &&`,
+		`while IFS= read -r deploygo_env && [ -n "$deploygo_env" ]; do eval "$deploygo_env"; done &&`,
+		"exec",
+	}
+	for _, arg := range interpreter {
+		remote = append(remote, shellQuote(arg))
+	}
+	remote = append(remote, "/dev/stdin")
+	for _, arg := range scriptArgs {
+		remote = append(remote, shellQuote(arg))
+	}
+
+	argv := []string{"ssh", "-o", "BatchMode=yes"}
+	if task.SSHKeyPath != "" {
+		argv = append(argv, "-i", task.SSHKeyPath)
+	}
+	if task.RemoteUser != "" {
+		argv = append(argv, "-l", task.RemoteUser)
+	}
+	return append(argv, "--", task.RemoteHost, strings.Join(remote, " "))
+}
+
+// sshEnvPreamble is sent to the remote shell ahead of the script: one export
+// per line, ended by an empty line. Newlines in values are written as $nl,
+// which sshCommand defines, so that each export stays on one line.
+func sshEnvPreamble(task DeploymentTask) string {
+	var preamble strings.Builder
+	for _, entry := range scriptEnv(task) {
+		key, value, _ := strings.Cut(entry, "=")
+		quoted := strings.ReplaceAll(shellQuote(value), "\n", `'"$nl"'`)
+		fmt.Fprintf(&preamble, "export %s=%s\n", key, quoted)
+	}
+	preamble.WriteString("\n")
+	return preamble.String()
+}
+
+// shellQuote quotes s for the remote POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runRemoteScript runs a local script on the remote host over SSH, streaming
+// its output into the deployment log like a local script.
+func runRemoteScript(ctx context.Context, task DeploymentTask, scriptPath string, scriptArgs []string, label string, logFile *deploymentLog, redactor *redactor, shipper *logShipper) error {
+	args, err := deferredScriptCommand(task.Interpreter, scriptPath)
+	if err != nil {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] Cannot run %s: %v", strings.ToLower(label), err))
+		return fmt.Errorf("cannot run %s: %v", strings.ToLower(label), err)
+	}
+	interpreter := args[:len(args)-1]
+
+	script, err := os.Open(scriptPath)
+	if err != nil {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] Cannot run %s: %v", strings.ToLower(label), err))
+		return fmt.Errorf("cannot run %s: %v", strings.ToLower(label), err)
+	}
+	defer script.Close()
+
+	writeLogEntry(logFile, fmt.Sprintf("%s interpreter: %s (on %s)", label, strings.Join(interpreter, " "), remoteTarget(task)))
+	stdin := io.MultiReader(strings.NewReader(sshEnvPreamble(task)), script)
+	err = runCommand(ctx, task, sshCommand(task, interpreter, scriptArgs), stdin, label, logFile, redactor, shipper)
+	if code, ok := exitCode(err); ok && code == sshConnectionFailed {
+		writeLogEntry(logFile, fmt.Sprintf("[ERROR] SSH to %s failed; see the ssh output above", remoteTarget(task)))
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const resultFileName = "result.json"
+
+// DeploymentResult is the machine-readable outcome written to result.json in
+// the log directory after every deployment.
+type DeploymentResult struct {
+	TaskID          string    `json:"taskId"`
+	ProjectPath     string    `json:"projectPath"`
+	ScriptPath      string    `json:"scriptPath"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	ExitCode        *int      `json:"exitCode,omitempty"`
+	Signal          *int      `json:"signal,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	QueuedSeconds   float64   `json:"queuedSeconds"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	EnvKeys         []string  `json:"envKeys"`
+	Artifacts       []string  `json:"artifacts,omitempty"`
+	LogFile         string    `json:"logFile"`
+	GitRef          string    `json:"gitRef,omitempty"`
+	GitSHA          string    `json:"gitSha,omitempty"`
+	TimedOutPhase   string    `json:"timedOutPhase,omitempty"`
+}
+
+// NewDeploymentResult summarises a finished deployment. status is one of
+// "success", "failed", "skipped" or "dry-run-success".
+func NewDeploymentResult(task DeploymentTask, status string, runErr error, startedAt, finishedAt time.Time) DeploymentResult {
+	result := DeploymentResult{
+		TaskID:          task.TaskID,
+		ProjectPath:     task.ProjectPath,
+		ScriptPath:      task.DeploymentScriptPath,
+		Status:          status,
+		CreatedAt:       task.CreatedAt,
+		StartedAt:       startedAt,
+		FinishedAt:      finishedAt,
+		QueuedSeconds:   startedAt.Sub(task.CreatedAt).Seconds(),
+		DurationSeconds: finishedAt.Sub(startedAt).Seconds(),
+		Artifacts:       task.ExpectArtifacts,
+		LogFile:         taskLogPath(task.LogPath, task.TaskID),
+		GitRef:          task.GitRef,
+		GitSHA:          gitHead(task.ProjectPath),
+	}
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	var timeout *phaseTimeoutError
+	if errors.As(runErr, &timeout) {
+		result.TimedOutPhase = timeout.phase
+	}
+	if code, ok := exitCode(runErr); ok {
+		result.ExitCode = &code
+	}
+	if sig, ok := exitSignal(runErr); ok {
+		signal := int(sig)
+		result.Signal = &signal
+	}
+	if merged, err := withEnvFile(task); err == nil {
+		task = merged
+	}
+	seen := map[string]bool{}
+	for _, entry := range scriptEnv(task) {
+		key, _, _ := strings.Cut(entry, "=")
+		if !seen[key] {
+			seen[key] = true
+			result.EnvKeys = append(result.EnvKeys, key)
+		}
+	}
+	return result
+}
+
+// WriteResult atomically replaces result.json in the log directory.
+func WriteResult(logDir string, result DeploymentResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %v", err)
+	}
+	return writeFileAtomic(filepath.Join(logDir, resultFileName), data, 0644)
+}
+
+// exitCode extracts the script's exit code from a deployment error. A nil
+// error is exit code 0; errors that never reached the script have none.
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// exitSignal reports the signal that terminated the script, if any. Such
+// scripts have no exit code.
+func exitSignal(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
+
+// describeExit words how a failed script ended for the log.
+func describeExit(err error) string {
+	if sig, ok := exitSignal(err); ok {
+		return fmt.Sprintf("was terminated by signal %d (%v)", int(sig), sig)
+	}
+	if code, ok := exitCode(err); ok {
+		return fmt.Sprintf("exited with code %d", code)
+	}
+	return fmt.Sprintf("exited with error: %v", err)
+}
+
+// gitHead returns the commit checked out in dir, or "" if it is not a git
+// working tree.
+func gitHead(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	// A unique temp name, so concurrent writers cannot rename each other's
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to replace %s: %v", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shipBufferSize    = 10000
+	shipBatchSize     = 200
+	shipFlushInterval = 2 * time.Second
+	shipTimeout       = 5 * time.Second
+	shipCloseTimeout  = 10 * time.Second
+)
+
+// logBatch is the JSON payload posted to the log collector.
+type logBatch struct {
+	TaskID      string   `json:"taskId"`
+	ProjectPath string   `json:"projectPath"`
+	Lines       []string `json:"lines"`
+}
+
+// logShipper forwards log lines to an external HTTP collector in batches.
+// Lines are queued on a buffered channel; when the buffer is full they are
+// dropped instead of blocking the deployment. A nil *logShipper is a no-op.
+type logShipper struct {
+	url    string
+	task   DeploymentTask
+	client *http.Client
+	lines  chan string
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	dropped   int
+	failed    int
+	lastErr   error
+	closeOnce sync.Once
+}
+
+func ValidateShipURL(rawURL string) error {
+	if err := checkHTTPURL(rawURL); err != nil {
+		return fmt.Errorf("log ship URL must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// checkHTTPURL accepts an empty string or an absolute http(s) URL.
+func checkHTTPURL(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("not an absolute http(s) URL: %q", rawURL)
+	}
+	return nil
+}
+
+func newLogShipper(task DeploymentTask) *logShipper {
+	if task.LogShipURL == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &logShipper{
+		url:    task.LogShipURL,
+		task:   task,
+		client: &http.Client{Timeout: shipTimeout},
+		lines:  make(chan string, shipBufferSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go s.run()
+	return s
+}
+
+// Ship queues a line for delivery without ever blocking the caller.
+func (s *logShipper) Ship(line string) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.lines <- strings.TrimSuffix(line, "\n"):
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+// Close flushes any queued lines and reports what could not be delivered.
+// It gives up after shipCloseTimeout, so a slow or hung collector cannot
+// hold up the end of the deployment; lines not yet sent count as dropped.
+func (s *logShipper) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() { close(s.lines) })
+	select {
+	case <-s.done:
+	case <-time.After(shipCloseTimeout):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed == 0 && s.dropped == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d batches failed, %d lines dropped (last error: %v)", s.failed, s.dropped, s.lastErr)
+}
+
+func (s *logShipper) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(shipFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]string, 0, shipBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if s.ctx.Err() != nil {
+			s.mu.Lock()
+			s.dropped += len(batch)
+			s.mu.Unlock()
+			batch = make([]string, 0, shipBatchSize)
+			return
+		}
+		if err := s.send(batch); err != nil {
+			s.mu.Lock()
+			s.failed++
+			s.lastErr = err
+			s.mu.Unlock()
+		}
+		batch = make([]string, 0, shipBatchSize)
+	}
+
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, line)
+			if len(batch) >= shipBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *logShipper) send(lines []string) error {
+	body, err := json.Marshal(logBatch{
+		TaskID:      s.task.TaskID,
+		ProjectPath: s.task.ProjectPath,
+		Lines:       lines,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownGrace = 30 * time.Second
+
+// shutdownGrace is how long a deployment may keep running after the process
+// is asked to stop, from DEPLOYER_SHUTDOWN_GRACE in seconds.
+func shutdownGrace() (time.Duration, error) {
+	value := os.Getenv("DEPLOYER_SHUTDOWN_GRACE")
+	if value == "" {
+		return defaultShutdownGrace, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("DEPLOYER_SHUTDOWN_GRACE must be a non-negative number of seconds")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// cancelOnSignal lets the running deployment finish when the process gets
+// SIGTERM or an interrupt, and calls cancel if it is still running after
+// grace or a second signal arrives. Once it has cancelled, it stops
+// listening, so a further signal terminates the process as usual. The
+// returned function stops listening for signals.
+func cancelOnSignal(task DeploymentTask, grace time.Duration, cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[INFO] Received %v; draining 1 in-flight deployment for up to %s", sig, grace))
+		case <-done:
+			return
+		}
+		select {
+		case <-time.After(grace):
+			WriteLog(task.LogPath, task.TaskID, "[WARNING] Shutdown grace period expired; cancelling deployment")
+		case sig := <-signals:
+			WriteLog(task.LogPath, task.TaskID, fmt.Sprintf("[WARNING] Received %v again; cancelling deployment", sig))
+		case <-done:
+			return
+		}
+		cancel()
+		signal.Stop(signals)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
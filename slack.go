@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var slackColors = map[string]string{
+	"success":         "#2eb886",
+	"dry-run-success": "#2eb886",
+	"failed":          "#a30200",
+	"skipped":         "#daa038",
+}
+
+// slackMessage is an incoming-webhook payload. Block Kit blocks have no
+// colour of their own, so they are wrapped in an attachment for the
+// status colour bar; Text is the notification fallback.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// sendSlackNotification posts the deployment outcome to the incoming webhook
+// in DEPLOYER_SLACK_WEBHOOK. It does nothing when the variable is unset.
+func sendSlackNotification(task DeploymentTask, result DeploymentResult) error {
+	webhook := os.Getenv("DEPLOYER_SLACK_WEBHOOK")
+	if webhook == "" {
+		return nil
+	}
+	if err := checkHTTPURL(webhook); err != nil {
+		return fmt.Errorf("DEPLOYER_SLACK_WEBHOOK: %v", err)
+	}
+
+	body, err := json.Marshal(newSlackMessage(task, result))
+	if err != nil {
+		return fmt.Errorf("failed to marshal Slack message: %v", err)
+	}
+	return postWithRetry(webhook, body)
+}
+
+func newSlackMessage(task DeploymentTask, result DeploymentResult) slackMessage {
+	project := filepath.Base(task.ProjectPath)
+	summary := fmt.Sprintf("Deployment of %s %s", project, result.Status)
+	duration := time.Duration(result.DurationSeconds * float64(time.Second)).Round(time.Second)
+
+	blocks := []slackBlock{
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*" + summary + "*"}},
+		{Type: "section", Fields: []slackText{
+			{Type: "mrkdwn", Text: "*Project*\n" + project},
+			{Type: "mrkdwn", Text: "*Status*\n" + result.Status},
+			{Type: "mrkdwn", Text: "*Task ID*\n`" + task.TaskID + "`"},
+			{Type: "mrkdwn", Text: "*Duration*\n" + duration.String()},
+		}},
+	}
+	if line := lastErrorLine(taskLogPath(task.LogPath, task.TaskID)); line != "" {
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "```" + line + "```"}})
+	}
+
+	color, ok := slackColors[result.Status]
+	if !ok {
+		color = slackColors["skipped"]
+	}
+	return slackMessage{
+		Text:        summary,
+		Attachments: []slackAttachment{{Color: color, Blocks: blocks}},
+	}
+}
+
+// lastErrorLine returns the most recent [ERROR] entry near the end of the log.
+func lastErrorLine(logFile string) string {
+	lines := logTail(logFile, callbackTailLines)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.Contains(lines[i], "[ERROR]") || strings.Contains(lines[i], `"level":"error"`) {
+			return lines[i]
+		}
+	}
+	return ""
+}